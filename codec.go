@@ -0,0 +1,227 @@
+package redisstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec transforms session payloads before they are written to Redis, and
+// reverses that transformation when they are read back. Implementations are
+// wired in via NewWithCodec (or Options.Codec) and are applied in Commit,
+// Find and All, so callers never see the encoded form.
+type Codec interface {
+	Encode(b []byte) ([]byte, error)
+	Decode(b []byte) ([]byte, error)
+}
+
+// ChainCodec composes codecs, applying them in order on Encode and in
+// reverse order on Decode. This lets e.g. compression and encryption be
+// combined: ChainCodec{gzipCodec, aesCodec} compresses before encrypting,
+// and decrypts before decompressing.
+type ChainCodec []Codec
+
+// Encode runs b through each codec in order, feeding each one's output into
+// the next.
+func (c ChainCodec) Encode(b []byte) ([]byte, error) {
+	var err error
+	for _, codec := range c {
+		b, err = codec.Encode(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Decode runs b through each codec in reverse order, undoing Encode.
+func (c ChainCodec) Decode(b []byte) ([]byte, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		b, err = c[i].Decode(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// GzipCodec compresses payloads with gzip, which shrinks large gob-encoded
+// sessions at the cost of a little CPU on every Commit and Find.
+type GzipCodec struct{}
+
+// Encode gzip-compresses b.
+func (GzipCodec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode gunzips b.
+func (GzipCodec) Decode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// ZstdCodec compresses payloads with zstd, which shrinks large gob-encoded
+// sessions harder than GzipCodec at comparable CPU cost. Use NewZstdCodec to
+// construct one; the zero value is not usable.
+type ZstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+// NewZstdCodec returns a ZstdCodec ready for use. Callers should keep it
+// around and reuse it (e.g. store it alongside the RedisStore) rather than
+// constructing one per call, since the encoder and decoder hold reusable
+// internal buffers.
+func NewZstdCodec() (*ZstdCodec, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ZstdCodec{enc: enc, dec: dec}, nil
+}
+
+// Encode zstd-compresses b.
+func (c *ZstdCodec) Encode(b []byte) ([]byte, error) {
+	return c.enc.EncodeAll(b, nil), nil
+}
+
+// Decode un-compresses b.
+func (c *ZstdCodec) Decode(b []byte) ([]byte, error) {
+	return c.dec.DecodeAll(b, nil)
+}
+
+// AESGCMCodec encrypts payloads at rest with AES-GCM under a single key. Use
+// KeysetCodec instead when keys need to be rotated without downtime.
+type AESGCMCodec struct {
+	gcm cipher.AEAD
+}
+
+// NewAESGCMCodec returns an AESGCMCodec keyed by key, which must be 16, 24 or
+// 32 bytes (AES-128, AES-192 or AES-256).
+func NewAESGCMCodec(key []byte) (*AESGCMCodec, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMCodec{gcm: gcm}, nil
+}
+
+// Encode encrypts b, prefixing the ciphertext with a random nonce.
+func (c *AESGCMCodec) Encode(b []byte) ([]byte, error) {
+	nonce := make([]byte, c.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+// Decode decrypts b, which must have been produced by Encode.
+func (c *AESGCMCodec) Decode(b []byte) ([]byte, error) {
+	nonceSize := c.gcm.NonceSize()
+	if len(b) < nonceSize {
+		return nil, fmt.Errorf("redisstore: ciphertext shorter than nonce")
+	}
+	nonce, ciphertext := b[:nonceSize], b[nonceSize:]
+	return c.gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeysetCodec encrypts with AES-GCM under a set of keys identified by a
+// single key-id byte, so keys can be rotated without downtime: deploy a
+// keyset with the new key as an additional trusted key, then once every
+// instance has it, make the new id the primary and (later) drop the old key
+// entirely. Sessions written under a retired key keep decrypting until they
+// naturally expire.
+type KeysetCodec struct {
+	primary byte
+	gcms    map[byte]cipher.AEAD
+}
+
+// NewKeysetCodec returns a KeysetCodec. keys maps a key id to its raw AES
+// key; primary selects which of those keys new sessions are encrypted with.
+// All entries in keys remain trusted for Decode.
+func NewKeysetCodec(keys map[byte][]byte, primary byte) (*KeysetCodec, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("redisstore: primary key id %d not present in keyset", primary)
+	}
+
+	gcms := make(map[byte]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("redisstore: key id %d: %w", id, err)
+		}
+		gcms[id] = gcm
+	}
+
+	return &KeysetCodec{primary: primary, gcms: gcms}, nil
+}
+
+// Encode encrypts b under the keyset's primary key, prefixing the result
+// with the key id and a random nonce.
+func (c *KeysetCodec) Encode(b []byte) ([]byte, error) {
+	gcm := c.gcms[c.primary]
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 1+len(nonce)+len(b)+gcm.Overhead())
+	out = append(out, c.primary)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, b, nil), nil
+}
+
+// Decode decrypts b using whichever keyset entry wrote it, identified by the
+// key id byte Encode prefixed it with.
+func (c *KeysetCodec) Decode(b []byte) ([]byte, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("redisstore: ciphertext missing key id")
+	}
+
+	id, b := b[0], b[1:]
+	gcm, ok := c.gcms[id]
+	if !ok {
+		return nil, fmt.Errorf("redisstore: unknown key id %d", id)
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(b) < nonceSize {
+		return nil, fmt.Errorf("redisstore: ciphertext shorter than nonce")
+	}
+	nonce, ciphertext := b[:nonceSize], b[nonceSize:]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,138 @@
+package redisstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGzipCodecRoundTrip(t *testing.T) {
+	c := GzipCodec{}
+
+	encoded, err := c.Encode([]byte("encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("encoded_data"))
+	}
+}
+
+func TestZstdCodecRoundTrip(t *testing.T) {
+	c, err := NewZstdCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := c.Encode([]byte("encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("encoded_data"))
+	}
+}
+
+func TestAESGCMCodecRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+
+	c, err := NewAESGCMCodec(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := c.Encode([]byte("encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, []byte("encoded_data")) {
+		t.Fatalf("encoded payload contains plaintext: %v", encoded)
+	}
+
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("encoded_data"))
+	}
+}
+
+func TestChainCodecRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x02}, 32)
+	aesCodec, err := NewAESGCMCodec(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ChainCodec{GzipCodec{}, aesCodec}
+
+	encoded, err := c.Encode([]byte("encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := c.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("encoded_data"))
+	}
+}
+
+// TestKeysetCodecRotation verifies that after rotating the primary key from
+// A to B, sessions written under A still decrypt (because A remains
+// trusted) while new sessions are encrypted under B.
+func TestKeysetCodecRotation(t *testing.T) {
+	keyA := bytes.Repeat([]byte{0xAA}, 32)
+	keyB := bytes.Repeat([]byte{0xBB}, 32)
+
+	before, err := NewKeysetCodec(map[byte][]byte{0: keyA}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldSession, err := before.Encode([]byte("old_encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := NewKeysetCodec(map[byte][]byte{0: keyA, 1: keyB}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := after.Decode(oldSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("old_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("old_encoded_data"))
+	}
+
+	newSession, err := after.Encode([]byte("new_encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSession[0] != 1 {
+		t.Fatalf("got key id %d: expected new sessions to be encrypted with key id %d", newSession[0], 1)
+	}
+
+	decoded, err = after.Decode(newSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(decoded, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", decoded, []byte("new_encoded_data"))
+	}
+}
@@ -2,15 +2,71 @@ package redisstore
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// defaultScanCount is the COUNT hint passed to SCAN when no Options.ScanCount
+// is supplied. It only sizes each cursor page, so it can be tuned without
+// affecting correctness.
+const defaultScanCount = 500
+
+// defaultTimeout is the deadline applied to the non-Ctx methods (Find,
+// Commit, Delete, All) when no Options.Timeout is supplied.
+const defaultTimeout = 5 * time.Second
+
+// ErrTokenNotFound is returned by CommitKeepTTL and CommitKeepTTLCtx when the
+// token has no existing session to update. SET ... KEEPTTL on a key with no
+// previous TTL is a no-op on the TTL, which would silently create an
+// immortal session, so these methods refuse with SET ... XX KEEPTTL instead
+// of risking that.
+var ErrTokenNotFound = errors.New("redisstore: token not found, refusing CommitKeepTTL to avoid creating a session with no expiry")
+
+// Options configures a RedisStore: its key prefix, how it enumerates
+// sessions in All, how it encodes payloads, and how long its non-Ctx methods
+// wait on Redis. The zero value uses the "scs:session:" prefix, scans the
+// whole keyspace with defaultScanCount as its page size, applies no codec,
+// and times out after defaultTimeout.
+type Options struct {
+	// Prefix controls the Redis key prefix, which can be used to avoid naming
+	// clashes if necessary. Defaults to "scs:session:".
+	Prefix string
+
+	// ScanCount is the COUNT hint used when paging through keys with SCAN.
+	// Defaults to defaultScanCount.
+	ScanCount int64
+
+	// UseIndex makes All walk a secondary set, namespaced separately from
+	// Prefix so no session token can collide with it, instead of scanning
+	// the whole keyspace. Commit adds tokens to this set and All lazily
+	// removes ones that have since expired. This trades a small write
+	// amplification on Commit for a cheap, targeted All.
+	UseIndex bool
+
+	// Codec, if set, transforms session payloads before they are written to
+	// Redis and reverses that transformation when they are read back. Use it
+	// for at-rest encryption and/or compression.
+	Codec Codec
+
+	// Timeout bounds each call to the non-Ctx methods (Find, Commit,
+	// CommitKeepTTL, Delete, All), covering every Redis round-trip that call
+	// makes. Defaults to defaultTimeout. It has no effect on the Ctx methods
+	// (FindCtx, CommitCtx, CommitKeepTTLCtx, DeleteCtx, AllCtx), which use
+	// whatever deadline the caller's context carries.
+	Timeout time.Duration
+}
+
 // RedisStore represents the session store.
 type RedisStore struct {
-	client *redis.Client
-	prefix string
+	client    redis.UniversalClient
+	prefix    string
+	scanCount int64
+	useIndex  bool
+	indexKey  string
+	codec     Codec
+	timeout   time.Duration
 }
 
 // New returns a new RedisStore instance. The pool parameter should be a pointer
@@ -23,21 +79,76 @@ func New(pool *redis.Client) *RedisStore {
 // to a redigo connection pool. The prefix parameter controls the Redis key
 // prefix, which can be used to avoid naming clashes if necessary.
 func NewWithPrefix(pool *redis.Client, prefix string) *RedisStore {
+	return NewWithClient(pool, prefix)
+}
+
+// NewWithClient returns a new RedisStore instance backed by any
+// redis.UniversalClient, i.e. a *redis.Client, *redis.FailoverClient (Sentinel)
+// or *redis.ClusterClient. This lets sessions survive primary failover or
+// scale across a Redis Cluster without swapping stores.
+//
+// Each session lives under a single key (prefix+token), so it always maps to
+// exactly one hash slot and needs no hash-tagging to work with Cluster.
+// All is the exception: it fetches each page of sessions with a multi-key
+// MGET, which Redis Cluster rejects when the keys hash to different slots,
+// and without UseIndex it pages with SCAN, which a *redis.ClusterClient sends
+// to a single node.
+func NewWithClient(client redis.UniversalClient, prefix string) *RedisStore {
+	return NewWithOptions(client, Options{Prefix: prefix})
+}
+
+// NewWithCodec returns a new RedisStore instance that runs every session
+// payload through codec before writing it to Redis and after reading it
+// back. See Codec for built-in encryption and compression implementations.
+func NewWithCodec(client redis.UniversalClient, prefix string, codec Codec) *RedisStore {
+	return NewWithOptions(client, Options{Prefix: prefix, Codec: codec})
+}
+
+// NewWithOptions returns a new RedisStore instance configured by opts. See
+// Options for the fields that can be tuned.
+func NewWithOptions(client redis.UniversalClient, opts Options) *RedisStore {
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "scs:session:"
+	}
+
+	scanCount := opts.ScanCount
+	if scanCount <= 0 {
+		scanCount = defaultScanCount
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
 	return &RedisStore{
-		client: pool,
-		prefix: prefix,
+		client:    client,
+		prefix:    prefix,
+		scanCount: scanCount,
+		useIndex:  opts.UseIndex,
+		indexKey:  "__scs_index__:" + prefix,
+		codec:     opts.Codec,
+		timeout:   timeout,
 	}
 }
 
 // Find returns the data for a given session token from the RedisStore instance.
 // If the session token is not found or is expired, the returned exists flag
 // will be set to false.
+//
+// It is a thin wrapper around FindCtx that applies the store's configured
+// Timeout to a background context.
 func (r *RedisStore) Find(token string) (b []byte, exists bool, err error) {
-	// Set a timeout for the request
-	back := context.Background()
-	ctx, cancel := context.WithTimeout(back, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
+	return r.FindCtx(ctx, token)
+}
+
+// FindCtx is like Find but takes an explicit context, so callers can tie the
+// Redis round-trip to a request's lifetime instead of a fixed timeout.
+func (r *RedisStore) FindCtx(ctx context.Context, token string) (b []byte, exists bool, err error) {
 	// Retrieve the value from Redis
 	b, err = r.client.Get(ctx, r.prefix+token).Bytes()
 	if err == redis.Nil {
@@ -46,77 +157,295 @@ func (r *RedisStore) Find(token string) (b []byte, exists bool, err error) {
 		return nil, false, err
 	}
 
+	if r.codec != nil {
+		b, err = r.codec.Decode(b)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
 	return b, true, nil
 }
 
 // Commit adds a session token and data to the RedisStore instance with the
 // given expiry time. If the session token already exists then the data and
 // expiry time are updated.
+//
+// It is a thin wrapper around CommitCtx that applies the store's configured
+// Timeout to a background context.
 func (r *RedisStore) Commit(token string, b []byte, expiry time.Time) error {
-	back := context.Background()
-	ctx, cancel := context.WithTimeout(back, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
-	// Using Pipelined to create a transaction-like operation
+	return r.CommitCtx(ctx, token, b, expiry)
+}
+
+// CommitCtx is like Commit but takes an explicit context, so callers can tie
+// the Redis round-trip to a request's lifetime instead of a fixed timeout.
+//
+// The value and its expiry are written with a single SET ... PX, rather than
+// a separate SET followed by PEXPIREAT: if the two were separate commands, a
+// connection or server crash between them could leave a session with no TTL
+// at all, making it immortal.
+func (r *RedisStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return r.DeleteCtx(ctx, token)
+	}
+
+	if r.codec != nil {
+		var err error
+		b, err = r.codec.Encode(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !r.useIndex {
+		return r.client.Set(ctx, r.prefix+token, b, ttl).Err()
+	}
+
 	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		pipe.Set(ctx, r.prefix+token, b, 0)         // Set the value
-		pipe.PExpireAt(ctx, r.prefix+token, expiry) // Set expiration time
+		pipe.Set(ctx, r.prefix+token, b, ttl)
+		pipe.SAdd(ctx, r.indexKey, token)
 		_, err := pipe.Exec(ctx)
 		return err
 	})
 
-	if err != nil {
+	return err
+}
+
+// CommitKeepTTL is like Commit but leaves the session's existing TTL
+// untouched instead of resetting it to expiry, for frameworks that want to
+// update session data without extending its lifetime. It returns
+// ErrTokenNotFound if token has no existing session to update.
+//
+// It is a thin wrapper around CommitKeepTTLCtx that applies the store's
+// configured Timeout to a background context.
+func (r *RedisStore) CommitKeepTTL(token string, b []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.CommitKeepTTLCtx(ctx, token, b)
+}
+
+// CommitKeepTTLCtx is like CommitKeepTTL but takes an explicit context, so
+// callers can tie the Redis round-trip to a request's lifetime instead of a
+// fixed timeout.
+//
+// The update is written with SET ... XX KEEPTTL: XX makes the write a no-op
+// (reported as ErrTokenNotFound) when the key is absent, so a token that was
+// never committed, or whose key already expired or was evicted, can't turn
+// into an immortal session the way a plain SET ... KEEPTTL would.
+func (r *RedisStore) CommitKeepTTLCtx(ctx context.Context, token string, b []byte) error {
+	if r.codec != nil {
+		var err error
+		b, err = r.codec.Encode(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.client.SetArgs(ctx, r.prefix+token, b, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err == redis.Nil {
+		return ErrTokenNotFound
+	} else if err != nil {
 		return err
 	}
 
+	if r.useIndex {
+		return r.client.SAdd(ctx, r.indexKey, token).Err()
+	}
+
 	return nil
 }
 
 // Delete removes a session token and corresponding data from the RedisStore
 // instance.
+//
+// It is a thin wrapper around DeleteCtx that applies the store's configured
+// Timeout to a background context.
 func (r *RedisStore) Delete(token string) error {
-	back := context.Background()
-	ctx, cancel := context.WithTimeout(back, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
-	err := r.client.Del(ctx, r.prefix+token).Err()
+	return r.DeleteCtx(ctx, token)
+}
+
+// DeleteCtx is like Delete but takes an explicit context, so callers can tie
+// the Redis round-trip to a request's lifetime instead of a fixed timeout.
+func (r *RedisStore) DeleteCtx(ctx context.Context, token string) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.prefix+token)
+		if r.useIndex {
+			pipe.SRem(ctx, r.indexKey, token)
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+
 	return err
 }
 
 // All returns a map containing the token and data for all active (i.e.
 // not expired) sessions in the RedisStore instance.
+//
+// By default it pages through the keyspace with SCAN, which unlike KEYS does
+// not block Redis while it runs. If the store was created with
+// Options.UseIndex, it instead walks the secondary token set maintained by
+// Commit and Delete, which is cheaper when the prefix shares the keyspace
+// with a lot of unrelated keys; any token found in the index whose key has
+// since expired is lazily removed from it.
+//
+// It is a thin wrapper around AllCtx that applies the store's configured
+// Timeout to a background context.
 func (r *RedisStore) All() (map[string][]byte, error) {
-	back := context.Background()
-	ctx, cancel := context.WithTimeout(back, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
-	keys, err := r.client.Keys(ctx, r.prefix+"*").Result()
-	if err == redis.Nil {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	return r.AllCtx(ctx)
+}
+
+// AllCtx is like All but takes an explicit context, so callers can tie the
+// Redis round-trip to a request's lifetime instead of a fixed timeout.
+func (r *RedisStore) AllCtx(ctx context.Context) (map[string][]byte, error) {
+	if r.useIndex {
+		return r.allFromIndex(ctx)
 	}
+	return r.allFromScan(ctx)
+}
 
+// allFromScan pages through the keyspace with SCAN and fetches each page of
+// values with a single MGET, rather than issuing KEYS plus N round-trip GETs.
+func (r *RedisStore) allFromScan(ctx context.Context) (map[string][]byte, error) {
 	sessions := make(map[string][]byte)
 
-	for _, key := range keys {
-		token := key[len(r.prefix):]
+	var cursor uint64
+	for {
+		var keys []string
+		var err error
+		keys, cursor, err = r.client.Scan(ctx, cursor, r.prefix+"*", r.scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			if err := r.mgetInto(ctx, keys, sessions); err != nil {
+				return nil, err
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return sessions, nil
+}
 
-		data, exists, err := r.Find(token)
-		if err == redis.Nil {
-			return nil, nil
-		} else if err != nil {
+// allFromIndex walks the secondary token-index set instead of scanning the
+// whole keyspace, fetching values in MGET-sized pages and pruning tokens
+// whose key has already expired.
+func (r *RedisStore) allFromIndex(ctx context.Context) (map[string][]byte, error) {
+	sessions := make(map[string][]byte)
+
+	var cursor uint64
+	for {
+		var tokens []string
+		var err error
+		tokens, cursor, err = r.client.SScan(ctx, r.indexKey, cursor, "", r.scanCount).Result()
+		if err != nil {
 			return nil, err
 		}
 
-		if exists {
-			sessions[token] = data
+		if len(tokens) > 0 {
+			keys := make([]string, len(tokens))
+			for i, token := range tokens {
+				keys[i] = r.prefix + token
+			}
+
+			values, err := r.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			var stale []string
+			for i, v := range values {
+				if v == nil {
+					stale = append(stale, tokens[i])
+					continue
+				}
+				s, ok := v.(string)
+				if !ok {
+					continue
+				}
+
+				data := []byte(s)
+				if r.codec != nil {
+					data, err = r.codec.Decode(data)
+					if err != nil {
+						return nil, err
+					}
+				}
+				sessions[tokens[i]] = data
+			}
+
+			if len(stale) > 0 {
+				if err := r.client.SRem(ctx, r.indexKey, toAny(stale)...).Err(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if cursor == 0 {
+			break
 		}
 	}
 
 	return sessions, nil
 }
 
+// mgetInto fetches keys with a single MGET and writes the decoded values into
+// sessions, keyed by token (the key with the store's prefix stripped).
+func (r *RedisStore) mgetInto(ctx context.Context, keys []string, sessions map[string][]byte) error {
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		data := []byte(s)
+		if r.codec != nil {
+			data, err = r.codec.Decode(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		token := keys[i][len(r.prefix):]
+		sessions[token] = data
+	}
+
+	return nil
+}
+
+// toAny adapts a []string to the []any SRem expects for its members.
+func toAny(s []string) []any {
+	out := make([]any, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
 func makeMillisecondTimestamp(t time.Time) int64 {
 	return t.UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 }
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -134,6 +136,348 @@ func TestExpiry(t *testing.T) {
 	}
 }
 
+// getFailoverClient returns a Sentinel-managed *redis.FailoverClient, as a
+// redis.UniversalClient, with its database flushed. It is skipped unless SCS_REDIS_SENTINEL_ADDRS is set,
+// since it requires a running Sentinel deployment.
+func getFailoverClient(t *testing.T) (context.Context, redis.UniversalClient) {
+	addrs := os.Getenv("SCS_REDIS_SENTINEL_ADDRS")
+	if addrs == "" {
+		t.Skip("SCS_REDIS_SENTINEL_ADDRS not set")
+	}
+
+	conn := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    os.Getenv("SCS_REDIS_SENTINEL_MASTER"),
+		SentinelAddrs: strings.Split(addrs, ","),
+		Password:      os.Getenv("SCS_REDIS_TEST_PASS"),
+	})
+	ctx := context.Background()
+
+	if _, err := conn.FlushDB(ctx).Result(); err != nil {
+		t.Fatal(err)
+	}
+
+	return ctx, conn
+}
+
+// getClusterClient returns a *redis.ClusterClient, as a
+// redis.UniversalClient, with FLUSHDB already sent. It is skipped unless SCS_REDIS_CLUSTER_ADDRS is set, since it requires a
+// running Cluster deployment.
+func getClusterClient(t *testing.T) (context.Context, redis.UniversalClient) {
+	addrs := os.Getenv("SCS_REDIS_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("SCS_REDIS_CLUSTER_ADDRS not set")
+	}
+
+	conn := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    strings.Split(addrs, ","),
+		Password: os.Getenv("SCS_REDIS_TEST_PASS"),
+	})
+	ctx := context.Background()
+
+	if err := conn.FlushDB(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return ctx, conn
+}
+
+func TestFindAndCommitWithFailoverClient(t *testing.T) {
+	ctx, conn := getFailoverClient(t)
+
+	r := NewWithClient(conn, "scs:session:")
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := conn.Get(ctx, r.prefix+"session_token").Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("encoded_data"))
+	}
+}
+
+func TestFindAndCommitWithClusterClient(t *testing.T) {
+	ctx, conn := getClusterClient(t)
+
+	r := NewWithClient(conn, "scs:session:")
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := conn.Get(ctx, r.prefix+"session_token").Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("encoded_data"))
+	}
+}
+
+// commandCountHook records the name of every command sent through a client,
+// so tests can assert exactly which commands a store method issued.
+type commandCountHook struct {
+	names []string
+}
+
+func (h *commandCountHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *commandCountHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.names = append(h.names, cmd.Name())
+		return next(ctx, cmd)
+	}
+}
+
+func (h *commandCountHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.names = append(h.names, cmd.Name())
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestCommitSendsASingleCommand(t *testing.T) {
+	_, conn := getClient(t)
+
+	hook := &commandCountHook{}
+	conn.AddHook(hook)
+
+	r := New(conn)
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hook.names) != 1 || hook.names[0] != "set" {
+		t.Fatalf("got %v: expected a single SET command, no separate expiry command", hook.names)
+	}
+}
+
+func TestCommitWithNonPositiveTTLDeletesKey(t *testing.T) {
+	ctx, conn := getClient(t)
+
+	r := New(conn)
+
+	err := conn.Set(ctx, r.prefix+"session_token", "encoded_data", NoExpiration).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Commit("session_token", []byte("encoded_data"), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := r.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestCommitKeepTTL(t *testing.T) {
+	ctx, conn := getClient(t)
+
+	r := New(conn)
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttlBefore, err := conn.PTTL(ctx, r.prefix+"session_token").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.CommitKeepTTL("session_token", []byte("new_encoded_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := conn.Get(ctx, r.prefix+"session_token").Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("new_encoded_data"))
+	}
+
+	ttlAfter, err := conn.PTTL(ctx, r.prefix+"session_token").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttlAfter > ttlBefore {
+		t.Fatalf("got ttl %v: expected ttl not to have been reset above %v", ttlAfter, ttlBefore)
+	}
+}
+
+func TestCommitKeepTTLWithoutExistingSessionFails(t *testing.T) {
+	ctx, conn := getClient(t)
+
+	r := New(conn)
+
+	err := r.CommitKeepTTL("missing_session_token", []byte("encoded_data"))
+	if !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("got %v: expected %v", err, ErrTokenNotFound)
+	}
+
+	exists, err := conn.Exists(ctx, r.prefix+"missing_session_token").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists != 0 {
+		t.Fatalf("got %v: expected CommitKeepTTL not to create an immortal session", exists)
+	}
+}
+
+func TestCtxMethodsRespectCancellation(t *testing.T) {
+	_, conn := getClient(t)
+
+	r := New(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := r.CommitCtx(ctx, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("got nil error: expected a context cancellation error")
+	}
+
+	if _, _, err := r.FindCtx(ctx, "session_token"); err == nil {
+		t.Fatal("got nil error: expected a context cancellation error")
+	}
+
+	if err := r.DeleteCtx(ctx, "session_token"); err == nil {
+		t.Fatal("got nil error: expected a context cancellation error")
+	}
+
+	if _, err := r.AllCtx(ctx); err == nil {
+		t.Fatal("got nil error: expected a context cancellation error")
+	}
+}
+
+func TestCommitAndFindWithCodec(t *testing.T) {
+	_, conn := getClient(t)
+
+	codec, err := NewAESGCMCodec(bytes.Repeat([]byte{0x03}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewWithCodec(conn, "scs:session:", codec)
+
+	err = r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := r.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestAllUsesScan(t *testing.T) {
+	_, conn := getClient(t)
+
+	r := NewWithOptions(conn, Options{Prefix: "scs:session:", ScanCount: 100})
+
+	const n = 10000
+	for i := 0; i < n; i++ {
+		token := "session_token_" + strconv.Itoa(i)
+		if err := r.Commit(token, []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != n {
+		t.Fatalf("got %d sessions: expected %d", len(sessions), n)
+	}
+}
+
+func TestIndexKeyDoesNotCollideWithLiteralTokenIndex(t *testing.T) {
+	_, conn := getClient(t)
+
+	r := NewWithOptions(conn, Options{Prefix: "scs:session:", UseIndex: true})
+
+	if err := r.Commit("other_token", []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A session token of literally "index" must not collide with the
+	// secondary index set's own key.
+	if err := r.Commit("index", []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions: expected %d", len(sessions), 2)
+	}
+	if _, found := sessions["other_token"]; !found {
+		t.Fatalf("other_token missing from All: index set was clobbered")
+	}
+	if _, found := sessions["index"]; !found {
+		t.Fatalf(`token "index" missing from All`)
+	}
+}
+
+func TestAllUsesIndex(t *testing.T) {
+	_, conn := getClient(t)
+
+	r := NewWithOptions(conn, Options{Prefix: "scs:session:", ScanCount: 100, UseIndex: true})
+
+	const n = 10000
+	for i := 0; i < n; i++ {
+		token := "session_token_" + strconv.Itoa(i)
+		if err := r.Commit(token, []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An expired session should be pruned from the index rather than
+	// surfacing as a stale entry.
+	if err := r.Commit("expiring_token", []byte("encoded_data"), time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	sessions, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != n {
+		t.Fatalf("got %d sessions: expected %d", len(sessions), n)
+	}
+	if _, found := sessions["expiring_token"]; found {
+		t.Fatalf("expired token %q should not be present in All", "expiring_token")
+	}
+}
+
 func TestDelete(t *testing.T) {
 	ctx, conn := getClient(t)
 